@@ -0,0 +1,50 @@
+package netsync
+
+import (
+	"testing"
+
+	"github.com/thetatoken/ukulele/core"
+)
+
+// TestBlockHashAndParentValidAcceptsGenuineBlock verifies that a block whose
+// Hash was actually computed by the chain's own CalculateHash is accepted -
+// i.e. blockHashAndParentValid compares against the real algorithm, not some
+// hash scheme invented just for this check.
+func TestBlockHashAndParentValidAcceptsGenuineBlock(t *testing.T) {
+	block := core.NewBlock()
+	block.Parent = []byte{1, 2, 3, 4}
+	block.Height = 5
+	block.Hash = block.CalculateHash()
+
+	if !blockHashAndParentValid(block) {
+		t.Fatalf("a block whose Hash matches its own CalculateHash() was rejected")
+	}
+}
+
+// TestBlockHashAndParentValidRejectsTamperedHash verifies that a block whose
+// claimed Hash no longer matches its contents (e.g. corrupted or forged in
+// transit) is rejected.
+func TestBlockHashAndParentValidRejectsTamperedHash(t *testing.T) {
+	block := core.NewBlock()
+	block.Parent = []byte{1, 2, 3, 4}
+	block.Height = 5
+	block.Hash = block.CalculateHash()
+	block.Hash[0] ^= 0xff
+
+	if blockHashAndParentValid(block) {
+		t.Fatalf("a block with a tampered Hash was accepted")
+	}
+}
+
+// TestBlockHashAndParentValidRejectsSelfReferentialParent verifies that a
+// block claiming itself as its own parent is rejected even though Hash and
+// Parent are each individually non-empty.
+func TestBlockHashAndParentValidRejectsSelfReferentialParent(t *testing.T) {
+	block := core.NewBlock()
+	block.Hash = block.CalculateHash()
+	block.Parent = block.Hash
+
+	if blockHashAndParentValid(block) {
+		t.Fatalf("a block referencing itself as its own parent was accepted")
+	}
+}
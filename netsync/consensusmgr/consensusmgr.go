@@ -0,0 +1,413 @@
+package consensusmgr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/thetatoken/ukulele/common"
+	"github.com/thetatoken/ukulele/common/util"
+	"github.com/thetatoken/ukulele/core"
+	"github.com/thetatoken/ukulele/dispatcher"
+	"github.com/thetatoken/ukulele/p2p"
+	"github.com/thetatoken/ukulele/p2p/peer"
+	"github.com/thetatoken/ukulele/rlp"
+
+	p2ptypes "github.com/thetatoken/ukulele/p2p/types"
+)
+
+// Misbehavior severity for a malformed consensus payload. Consensus traffic
+// comes straight from validators, so a bad payload is less likely to be an
+// honest decode race than outright corruption/hostility.
+const misbehaviorSeverityInvalidPayload = 20
+
+const (
+	// seenMsgsCacheSize bounds the ingress dedup cache, keyed by
+	// (peerID, msgHash). Sized generously since validator sets can be large
+	// and votes/proposals/CCs all share the cache.
+	seenMsgsCacheSize = 65536
+
+	// knownFilterCacheSize bounds each per-peer "already has this message"
+	// filter used to skip redundant rebroadcasts.
+	knownFilterCacheSize = 8192
+
+	// knownSweepInterval is how often `known` is swept for peers that have
+	// since disconnected, so it doesn't grow by one LRU cache per distinct
+	// peerID ever seen over the process's lifetime.
+	knownSweepInterval = 5 * time.Minute
+)
+
+// BlockFetcher lets the ConsensusManager ask the sync layer to fetch a block
+// it doesn't yet have locally, e.g. one referenced by an incoming vote or
+// proposal.
+type BlockFetcher interface {
+	FetchBlock(hash common.Hash)
+}
+
+// MessageConsumer is anything that wants to receive decoded votes,
+// proposals, and CC-derived votes as they arrive.
+type MessageConsumer interface {
+	AddMessage(interface{})
+}
+
+var _ p2p.MessageHandler = (*ConsensusManager)(nil)
+
+// ConsensusManager is the network-facing counterpart to the consensus
+// engine. It owns the consensus-critical gossip channels (proposals, votes,
+// CCs), which used to live on SyncManager, so that a large validator set's
+// block-sync traffic can't starve or be starved by consensus traffic. It
+// shares a PeerTable with SyncManager but registers as its own
+// p2p.MessageHandler.
+type ConsensusManager struct {
+	consensus    core.ConsensusEngine
+	consumer     MessageConsumer
+	dispatcher   *dispatcher.Dispatcher
+	peerTable    *peer.PeerTable
+	blockFetcher BlockFetcher
+
+	eventBus *EventBus
+
+	seenMsgs *lru.Cache // key: peerID+"|"+msgHash -> struct{}{}
+
+	knownMutex *sync.Mutex
+	known      map[string]*lru.Cache // peerID -> LRU of msgHash the peer is known to already have
+
+	wg     *sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	incoming chan p2ptypes.Message
+
+	logger *log.Entry
+}
+
+// NewConsensusManager creates a ConsensusManager sharing ptbl with the
+// SyncManager. fetcher lets it ask the sync layer to backfill a block
+// referenced by an incoming vote/proposal.
+func NewConsensusManager(cons core.ConsensusEngine, network p2p.Network, disp *dispatcher.Dispatcher, ptbl *peer.PeerTable, fetcher BlockFetcher, consumer MessageConsumer) *ConsensusManager {
+	seenMsgs, err := lru.New(seenMsgsCacheSize)
+	if err != nil {
+		panic(err)
+	}
+
+	cm := &ConsensusManager{
+		consensus:    cons,
+		consumer:     consumer,
+		dispatcher:   disp,
+		peerTable:    ptbl,
+		blockFetcher: fetcher,
+
+		eventBus: NewEventBus(),
+
+		seenMsgs: seenMsgs,
+
+		knownMutex: &sync.Mutex{},
+		known:      make(map[string]*lru.Cache),
+
+		wg:       &sync.WaitGroup{},
+		incoming: make(chan p2ptypes.Message, 256),
+	}
+	network.RegisterMessageHandler(cm)
+
+	cm.logger = util.GetLoggerForModule("consensusmgr")
+
+	return cm
+}
+
+// Subscribe returns a channel of consensus manager events (currently just
+// NewBlockProposeEvent), for RPC and other subsystems.
+func (cm *ConsensusManager) Subscribe() <-chan interface{} {
+	return cm.eventBus.Subscribe()
+}
+
+func (cm *ConsensusManager) Start(ctx context.Context) {
+	c, cancel := context.WithCancel(ctx)
+	cm.ctx = c
+	cm.cancel = cancel
+
+	cm.wg.Add(1)
+	go cm.mainLoop()
+}
+
+func (cm *ConsensusManager) Stop() {
+	cm.cancel()
+}
+
+func (cm *ConsensusManager) Wait() {
+	cm.wg.Wait()
+}
+
+func (cm *ConsensusManager) mainLoop() {
+	defer cm.wg.Done()
+
+	ticker := time.NewTicker(knownSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cm.ctx.Done():
+			return
+		case msg := <-cm.incoming:
+			cm.processMessage(msg)
+		case <-ticker.C:
+			cm.sweepKnown()
+		}
+	}
+}
+
+// sweepKnown drops the per-peer known-message filter for any peer no longer
+// in the PeerTable, reclaiming the LRU caches of peers that have
+// disconnected instead of holding onto them for the life of the process.
+func (cm *ConsensusManager) sweepKnown() {
+	cm.knownMutex.Lock()
+	defer cm.knownMutex.Unlock()
+
+	for peerID := range cm.known {
+		if cm.peerTable.GetPeer(peerID) == nil {
+			delete(cm.known, peerID)
+		}
+	}
+}
+
+// GetChannelIDs implements the p2p.MessageHandler interface.
+func (cm *ConsensusManager) GetChannelIDs() []common.ChannelIDEnum {
+	return []common.ChannelIDEnum{
+		common.ChannelIDProposal,
+		common.ChannelIDVote,
+		common.ChannelIDCC,
+	}
+}
+
+// ParseMessage implements the p2p.MessageHandler interface. Consensus
+// messages are always pushed as a DataResponse envelope, so unlike
+// SyncManager there's no InventoryRequest/Response framing to decode.
+func (cm *ConsensusManager) ParseMessage(peerID string, channelID common.ChannelIDEnum,
+	rawMessageBytes common.Bytes) (p2ptypes.Message, error) {
+	message := p2ptypes.Message{
+		PeerID:    peerID,
+		ChannelID: channelID,
+	}
+	data := dispatcher.DataResponse{}
+	err := rlp.DecodeBytes(rawMessageBytes, &data)
+	message.Content = data
+	return message, err
+}
+
+// EncodeMessage implements the p2p.MessageHandler interface.
+func (cm *ConsensusManager) EncodeMessage(message interface{}) (common.Bytes, error) {
+	return rlp.EncodeToBytes(message)
+}
+
+// HandleMessage implements the p2p.MessageHandler interface.
+func (cm *ConsensusManager) HandleMessage(msg p2ptypes.Message) (err error) {
+	cm.incoming <- msg
+	return
+}
+
+func (cm *ConsensusManager) processMessage(message p2ptypes.Message) {
+	data, ok := message.Content.(dispatcher.DataResponse)
+	if !ok {
+		cm.logger.WithFields(log.Fields{
+			"message": message,
+		}).Panic("Received unknown message")
+		return
+	}
+	cm.handleDataResponse(message.PeerID, &data)
+}
+
+func (cm *ConsensusManager) handleDataResponse(peerID string, data *dispatcher.DataResponse) {
+	msgHash := hashPayload(data.Payload)
+	if cm.isSeen(peerID, msgHash) {
+		return
+	}
+
+	// markSeen/markPeerKnows are deferred until the payload decodes
+	// successfully below, so a peer that repeats the same malformed bytes
+	// keeps hitting the decode-failure branch (and keeps accumulating
+	// misbehavior score) instead of being silently deduped here.
+	switch data.ChannelID {
+	case common.ChannelIDProposal:
+		proposal := &core.Proposal{}
+		if err := rlp.DecodeBytes(data.Payload, proposal); err != nil {
+			cm.logger.WithFields(log.Fields{
+				"channelID": data.ChannelID,
+				"error":     err,
+			}).Error("Failed to decode DataResponse payload")
+			cm.peerTable.ReportMisbehavior(peerID, peer.MisbehaviorInvalidPayload, misbehaviorSeverityInvalidPayload)
+			return
+		}
+		cm.handleProposal(proposal)
+	case common.ChannelIDVote:
+		vote := &core.Vote{}
+		if err := rlp.DecodeBytes(data.Payload, vote); err != nil {
+			cm.logger.WithFields(log.Fields{
+				"channelID": data.ChannelID,
+				"error":     err,
+			}).Error("Failed to decode DataResponse payload")
+			cm.peerTable.ReportMisbehavior(peerID, peer.MisbehaviorInvalidPayload, misbehaviorSeverityInvalidPayload)
+			return
+		}
+		cm.handleVote(vote)
+	case common.ChannelIDCC:
+		cc := &core.CommitCertificate{}
+		if err := rlp.DecodeBytes(data.Payload, cc); err != nil {
+			cm.logger.WithFields(log.Fields{
+				"channelID": data.ChannelID,
+				"error":     err,
+			}).Error("Failed to decode DataResponse payload")
+			cm.peerTable.ReportMisbehavior(peerID, peer.MisbehaviorInvalidPayload, misbehaviorSeverityInvalidPayload)
+			return
+		}
+		cm.handleCC(cc)
+	default:
+		cm.logger.WithFields(log.Fields{
+			"channelID": data.ChannelID,
+		}).Error("Unsupported channelID in received DataResponse")
+		return
+	}
+
+	cm.markSeen(peerID, msgHash)
+	cm.markPeerKnows(peerID, msgHash)
+
+	cm.relay(data.ChannelID, msgHash, peerID, data.Payload)
+}
+
+func (cm *ConsensusManager) handleProposal(p *core.Proposal) {
+	if p.CommitCertificate != nil {
+		cm.handleCC(p.CommitCertificate)
+	}
+	if p.Block != nil && cm.blockFetcher != nil {
+		cm.blockFetcher.FetchBlock(p.Block.Hash)
+	}
+	cm.consumer.AddMessage(p)
+}
+
+func (cm *ConsensusManager) handleCC(cc *core.CommitCertificate) {
+	for _, vote := range cc.Votes.Votes() {
+		cm.consumer.AddMessage(&vote)
+	}
+}
+
+func (cm *ConsensusManager) handleVote(vote *core.Vote) {
+	if vote.Block != nil && cm.blockFetcher != nil {
+		cm.blockFetcher.FetchBlock(vote.Block.Hash)
+	}
+	cm.consumer.AddMessage(vote)
+}
+
+// OnNewBlockPropose is called by the consensus engine, instead of calling
+// the dispatcher directly, when it wants to propose a new block. A
+// proposal must reach the entire validator set, so it uses
+// GetBroadcastSelectionFull rather than the fractional selection used for
+// relaying gossip.
+func (cm *ConsensusManager) OnNewBlockPropose(block *core.Block) error {
+	proposal := &core.Proposal{Block: block}
+	payload, err := rlp.EncodeToBytes(proposal)
+	if err != nil {
+		return err
+	}
+	msgHash := hashPayload(payload)
+
+	selection := cm.peerTable.GetBroadcastSelectionFull(common.ChannelIDProposal, []string{})
+	peerIDs := make([]string, len(selection))
+	for i, p := range selection {
+		peerIDs[i] = p.ID()
+		cm.markPeerKnows(p.ID(), msgHash)
+	}
+	cm.dispatcher.SendData(peerIDs, dispatcher.DataResponse{ChannelID: common.ChannelIDProposal, Payload: payload})
+
+	cm.eventBus.Publish(NewBlockProposeEvent{Block: block})
+	return nil
+}
+
+// OnBlockSignature is called by the consensus engine, instead of calling the
+// dispatcher directly, when it has signed a vote for the given block hash.
+func (cm *ConsensusManager) OnBlockSignature(hash common.Hash, sig []byte) error {
+	vote := &core.Vote{Block: &core.Block{Hash: hash}, Signature: common.Bytes(sig)}
+	payload, err := rlp.EncodeToBytes(vote)
+	if err != nil {
+		return err
+	}
+	msgHash := hashPayload(payload)
+
+	selection := cm.peerTable.GetBroadcastSelection(common.ChannelIDVote, []string{})
+	if len(selection) == 0 {
+		return nil
+	}
+	peerIDs := make([]string, len(selection))
+	for i, p := range selection {
+		peerIDs[i] = p.ID()
+		cm.markPeerKnows(p.ID(), msgHash)
+	}
+	cm.dispatcher.SendData(peerIDs, dispatcher.DataResponse{ChannelID: common.ChannelIDVote, Payload: payload})
+	return nil
+}
+
+// relay fans a just-received consensus message back out to a fractional
+// selection of peers, skipping any peer already known (via knownVotes/
+// knownProposals-style per-peer filter) to have this exact message.
+func (cm *ConsensusManager) relay(channelID common.ChannelIDEnum, msgHash string, fromPeerID string, payload common.Bytes) {
+	selection := cm.peerTable.GetBroadcastSelection(channelID, []string{fromPeerID})
+	if len(selection) == 0 {
+		return
+	}
+
+	peerIDs := make([]string, 0, len(selection))
+	for _, p := range selection {
+		if cm.peerKnows(p.ID(), msgHash) {
+			continue
+		}
+		peerIDs = append(peerIDs, p.ID())
+		cm.markPeerKnows(p.ID(), msgHash)
+	}
+	if len(peerIDs) == 0 {
+		return
+	}
+	cm.dispatcher.SendData(peerIDs, dispatcher.DataResponse{ChannelID: channelID, Payload: payload})
+}
+
+// hashPayload derives the dedup key for a raw consensus message.
+func hashPayload(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func (cm *ConsensusManager) isSeen(peerID string, msgHash string) bool {
+	_, seen := cm.seenMsgs.Get(peerID + "|" + msgHash)
+	return seen
+}
+
+func (cm *ConsensusManager) markSeen(peerID string, msgHash string) {
+	cm.seenMsgs.Add(peerID+"|"+msgHash, struct{}{})
+}
+
+// peerKnows indicates whether peerID is already known to have msgHash,
+// either because they sent it to us or because we already relayed it to
+// them.
+func (cm *ConsensusManager) peerKnows(peerID string, msgHash string) bool {
+	cache := cm.knownCacheFor(peerID)
+	_, known := cache.Get(msgHash)
+	return known
+}
+
+func (cm *ConsensusManager) markPeerKnows(peerID string, msgHash string) {
+	cm.knownCacheFor(peerID).Add(msgHash, struct{}{})
+}
+
+func (cm *ConsensusManager) knownCacheFor(peerID string) *lru.Cache {
+	cm.knownMutex.Lock()
+	defer cm.knownMutex.Unlock()
+
+	cache, exists := cm.known[peerID]
+	if !exists {
+		cache, _ = lru.New(knownFilterCacheSize)
+		cm.known[peerID] = cache
+	}
+	return cache
+}
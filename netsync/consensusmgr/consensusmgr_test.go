@@ -0,0 +1,47 @@
+package consensusmgr
+
+import (
+	"sync"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/thetatoken/ukulele/p2p/peer"
+)
+
+func newTestConsensusManager() *ConsensusManager {
+	pt := peer.CreatePeerTable()
+	return &ConsensusManager{
+		peerTable:  &pt,
+		knownMutex: &sync.Mutex{},
+		known:      make(map[string]*lru.Cache),
+	}
+}
+
+// TestSweepKnownDropsDisconnectedPeers verifies that sweepKnown reclaims the
+// known-message filter for peers no longer in the PeerTable, while leaving
+// still-connected peers' filters intact.
+func TestSweepKnownDropsDisconnectedPeers(t *testing.T) {
+	cm := newTestConsensusManager()
+
+	connected := peer.CreatePeer("connected", nil, true)
+	if !cm.peerTable.AddPeer(connected) {
+		t.Fatalf("failed to add connected peer to PeerTable")
+	}
+
+	cm.markPeerKnows("connected", "hash1")
+	cm.markPeerKnows("disconnected", "hash1")
+
+	if len(cm.known) != 2 {
+		t.Fatalf("expected 2 entries in known before sweep, got %d", len(cm.known))
+	}
+
+	cm.sweepKnown()
+
+	if _, exists := cm.known["disconnected"]; exists {
+		t.Fatalf("sweepKnown left a filter for a peer no longer in the PeerTable")
+	}
+	if _, exists := cm.known["connected"]; !exists {
+		t.Fatalf("sweepKnown dropped the filter for a still-connected peer")
+	}
+}
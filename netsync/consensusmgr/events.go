@@ -0,0 +1,58 @@
+package consensusmgr
+
+import (
+	"sync"
+
+	"github.com/thetatoken/ukulele/core"
+)
+
+// NewBlockProposeEvent is published whenever this node proposes a new block
+// through OnNewBlockPropose; proposals received from peers are not published.
+// RPC and other subsystems subscribe to the
+// EventBus to observe it instead of being wired directly into the consensus
+// manager.
+type NewBlockProposeEvent struct {
+	Block *core.Block
+}
+
+// eventBusSubscriberBuffer bounds how many unconsumed events a slow
+// subscriber can queue before further events are dropped for it.
+const eventBusSubscriberBuffer = 16
+
+// EventBus is a minimal fan-out publish/subscribe channel used to decouple
+// the consensus manager from whoever is interested in its events (RPC,
+// logging, etc).
+type EventBus struct {
+	mutex       sync.Mutex
+	subscribers []chan interface{}
+}
+
+// NewEventBus creates an empty EventBus
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe returns a channel that receives every event published from this
+// point on.
+func (eb *EventBus) Subscribe() <-chan interface{} {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	ch := make(chan interface{}, eventBusSubscriberBuffer)
+	eb.subscribers = append(eb.subscribers, ch)
+	return ch
+}
+
+// Publish fans event out to every current subscriber. A subscriber whose
+// buffer is full is skipped rather than blocking the publisher.
+func (eb *EventBus) Publish(event interface{}) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	for _, ch := range eb.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package netsync
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/thetatoken/ukulele/common"
+	"github.com/thetatoken/ukulele/core"
+	"github.com/thetatoken/ukulele/dispatcher"
+)
+
+const (
+	// requestTimeout is how long RequestManager waits for a block to arrive
+	// before giving up on whichever peer it asked and trying another one.
+	requestTimeout = 20 * time.Second
+
+	// requestRetryInterval is how often pending requests are checked for
+	// timeout.
+	requestRetryInterval = 5 * time.Second
+)
+
+// pendingRequest tracks an outstanding request for a single block hash.
+type pendingRequest struct {
+	hash        common.Hash
+	triedPeers  []string
+	requestedAt time.Time
+}
+
+// RequestManager fetches block data by hash on behalf of SyncManager. It
+// prefers SyncManager.PickSyncPeer (BestPeer(), falling back through
+// PeersAboveHeight) to pick who to ask, rather than broadcasting a
+// DataRequest to the entire peer set, and re-picks a peer excluding
+// whoever's already been tried when a request times out.
+type RequestManager struct {
+	sm *SyncManager
+
+	mutex   *sync.Mutex
+	pending map[string]*pendingRequest // hex(hash) -> pendingRequest
+
+	C chan *core.Block
+
+	wg     *sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewRequestManager creates a RequestManager for the given SyncManager.
+func NewRequestManager(sm *SyncManager) *RequestManager {
+	return &RequestManager{
+		sm: sm,
+
+		mutex:   &sync.Mutex{},
+		pending: make(map[string]*pendingRequest),
+
+		C: make(chan *core.Block, 128),
+
+		wg: &sync.WaitGroup{},
+	}
+}
+
+func (rm *RequestManager) Start(ctx context.Context) {
+	c, cancel := context.WithCancel(ctx)
+	rm.ctx = c
+	rm.cancel = cancel
+
+	rm.wg.Add(1)
+	go rm.mainLoop()
+}
+
+func (rm *RequestManager) Wait() {
+	rm.wg.Wait()
+}
+
+// AddHash registers a newly-learned block hash to fetch, preferring
+// knownPeers[0] (typically whoever advertised the hash) for the first
+// attempt if given.
+func (rm *RequestManager) AddHash(hash common.Hash, knownPeers []string) {
+	key := hex.EncodeToString(hash)
+
+	rm.mutex.Lock()
+	if _, exists := rm.pending[key]; exists {
+		rm.mutex.Unlock()
+		return
+	}
+	rm.pending[key] = &pendingRequest{hash: hash}
+	rm.mutex.Unlock()
+
+	preferredPeer := ""
+	if len(knownPeers) > 0 {
+		preferredPeer = knownPeers[0]
+	}
+	rm.request(key, preferredPeer)
+}
+
+// AddBlock records a block as delivered, clearing any pending request for it
+// and forwarding it to SyncManager via C.
+func (rm *RequestManager) AddBlock(block *core.Block) {
+	key := hex.EncodeToString(block.Hash)
+
+	rm.mutex.Lock()
+	delete(rm.pending, key)
+	rm.mutex.Unlock()
+
+	rm.C <- block
+}
+
+func (rm *RequestManager) mainLoop() {
+	defer rm.wg.Done()
+
+	ticker := time.NewTicker(requestRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rm.ctx.Done():
+			return
+		case <-ticker.C:
+			rm.retryTimedOut()
+		}
+	}
+}
+
+// retryTimedOut re-requests any pending hash whose last request has been
+// outstanding longer than requestTimeout, picking a peer other than the ones
+// already tried.
+func (rm *RequestManager) retryTimedOut() {
+	now := time.Now()
+
+	rm.mutex.Lock()
+	var timedOut []string
+	for key, req := range rm.pending {
+		if now.Sub(req.requestedAt) >= requestTimeout {
+			timedOut = append(timedOut, key)
+		}
+	}
+	rm.mutex.Unlock()
+
+	for _, key := range timedOut {
+		rm.request(key, "")
+	}
+}
+
+// request asks preferredPeer (if given and not already tried for this hash)
+// for the corresponding block, otherwise defers to SyncManager.PickSyncPeer,
+// which favors BestPeer() and falls back through PeersAboveHeight over the
+// peers already tried for this hash.
+func (rm *RequestManager) request(key string, preferredPeer string) {
+	rm.mutex.Lock()
+	req, exists := rm.pending[key]
+	if !exists {
+		rm.mutex.Unlock()
+		return
+	}
+	tried := make([]string, len(req.triedPeers))
+	copy(tried, req.triedPeers)
+	rm.mutex.Unlock()
+
+	alreadyTried := false
+	for _, peerID := range tried {
+		if peerID == preferredPeer {
+			alreadyTried = true
+			break
+		}
+	}
+
+	peerID := preferredPeer
+	if peerID == "" || alreadyTried {
+		p := rm.sm.PickSyncPeer(tried)
+		if p == nil {
+			return
+		}
+		peerID = p.ID()
+	}
+
+	rm.mutex.Lock()
+	req.triedPeers = append(req.triedPeers, peerID)
+	req.requestedAt = time.Now()
+	rm.mutex.Unlock()
+
+	rm.sm.logger.WithFields(log.Fields{
+		"hash": key,
+		"peer": peerID,
+	}).Debug("Requesting block")
+
+	rm.sm.dispatcher.SendData([]string{peerID}, dispatcher.DataRequest{
+		ChannelID: common.ChannelIDBlock,
+		Entries:   []string{key},
+	})
+}
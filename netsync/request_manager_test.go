@@ -0,0 +1,127 @@
+package netsync
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/thetatoken/ukulele/common"
+	"github.com/thetatoken/ukulele/core"
+	"github.com/thetatoken/ukulele/p2p/peer"
+)
+
+// TestPickSyncPeerPrefersBestPeer verifies that PickSyncPeer hands back the
+// tallest-chain peer when it isn't excluded.
+func TestPickSyncPeerPrefersBestPeer(t *testing.T) {
+	short := peer.CreatePeer("short", nil, true)
+	short.SetChainStatus(10, common.Hash{1})
+	tall := peer.CreatePeer("tall", nil, true)
+	tall.SetChainStatus(20, common.Hash{2})
+
+	sm := newTestSyncManager(short, tall)
+
+	picked := sm.PickSyncPeer([]string{})
+	if picked == nil || picked.ID() != "tall" {
+		t.Fatalf("PickSyncPeer([]) = %v, want tall", picked)
+	}
+}
+
+// TestPickSyncPeerFallsBackWhenBestPeerExcluded verifies that PickSyncPeer
+// falls back to another candidate via PeersAboveHeight when BestPeer has
+// already been tried.
+func TestPickSyncPeerFallsBackWhenBestPeerExcluded(t *testing.T) {
+	short := peer.CreatePeer("short", nil, true)
+	short.SetChainStatus(10, common.Hash{1})
+	tall := peer.CreatePeer("tall", nil, true)
+	tall.SetChainStatus(20, common.Hash{2})
+
+	sm := newTestSyncManager(short, tall)
+
+	picked := sm.PickSyncPeer([]string{"tall"})
+	if picked == nil || picked.ID() != "short" {
+		t.Fatalf("PickSyncPeer([tall]) = %v, want short", picked)
+	}
+}
+
+// TestPickSyncPeerReturnsNilWhenAllExcluded verifies that PickSyncPeer gives
+// up rather than returning an already-tried peer.
+func TestPickSyncPeerReturnsNilWhenAllExcluded(t *testing.T) {
+	only := peer.CreatePeer("only", nil, true)
+	only.SetChainStatus(10, common.Hash{1})
+
+	sm := newTestSyncManager(only)
+
+	if picked := sm.PickSyncPeer([]string{"only"}); picked != nil {
+		t.Fatalf("PickSyncPeer([only]) = %v, want nil", picked)
+	}
+}
+
+// TestRequestManagerAddHashDedupesPendingHash verifies that registering the
+// same hash twice only creates one pending entry. The SyncManager here has
+// no peers, so PickSyncPeer returns nil and request() bails out before
+// reaching the dispatcher, letting this run without a live dispatcher.
+func TestRequestManagerAddHashDedupesPendingHash(t *testing.T) {
+	sm := newTestSyncManager()
+	rm := NewRequestManager(sm)
+
+	hash := common.Hash{1, 2, 3}
+	rm.AddHash(hash, nil)
+	rm.AddHash(hash, nil)
+
+	if len(rm.pending) != 1 {
+		t.Fatalf("len(rm.pending) = %d, want 1 after registering the same hash twice", len(rm.pending))
+	}
+}
+
+// TestRequestManagerRetryTimedOutLeavesPendingWhenNoPeerAvailable verifies
+// that retryTimedOut identifies a stale pending request and attempts to
+// re-request it, but doesn't lose or corrupt the entry when no peer is
+// available to ask.
+func TestRequestManagerRetryTimedOutLeavesPendingWhenNoPeerAvailable(t *testing.T) {
+	sm := newTestSyncManager()
+	rm := NewRequestManager(sm)
+
+	hash := common.Hash{4, 5, 6}
+	key := hex.EncodeToString(hash)
+	rm.pending[key] = &pendingRequest{
+		hash:        hash,
+		requestedAt: time.Now().Add(-2 * requestTimeout),
+	}
+
+	rm.retryTimedOut()
+
+	req, exists := rm.pending[key]
+	if !exists {
+		t.Fatalf("retryTimedOut dropped a pending entry it couldn't find a peer for")
+	}
+	if len(req.triedPeers) != 0 {
+		t.Fatalf("triedPeers = %v, want empty since no peer was available to try", req.triedPeers)
+	}
+}
+
+// TestRequestManagerAddBlockClearsPendingAndForwards verifies that
+// delivering a block clears its pending request and forwards it on C.
+func TestRequestManagerAddBlockClearsPendingAndForwards(t *testing.T) {
+	sm := newTestSyncManager()
+	rm := NewRequestManager(sm)
+
+	block := core.NewBlock()
+	block.Hash = common.Hash{7, 8, 9}
+	key := hex.EncodeToString(block.Hash)
+	rm.pending[key] = &pendingRequest{hash: block.Hash}
+
+	rm.AddBlock(block)
+
+	if _, exists := rm.pending[key]; exists {
+		t.Fatalf("AddBlock left a pending entry for a block it just delivered")
+	}
+
+	select {
+	case delivered := <-rm.C:
+		if delivered != block {
+			t.Fatalf("received block %v, want %v", delivered, block)
+		}
+	default:
+		t.Fatalf("AddBlock did not forward the block on C")
+	}
+}
@@ -0,0 +1,124 @@
+package netsync
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/thetatoken/ukulele/common"
+)
+
+// statusGossipInterval is how often a node announces its own chain tip to
+// its peers on ChannelIDStatus.
+const statusGossipInterval = 10 * time.Second
+
+// statusPullInterval is how often a node checks for peers it has no chain
+// status for yet (e.g. just connected, or reconnected after a timeout) and
+// pulls their tip on demand, instead of waiting up to statusGossipInterval
+// for the other side's next periodic push.
+const statusPullInterval = 1 * time.Second
+
+// StatusRequest asks a peer to report its current chain tip.
+type StatusRequest struct{}
+
+// StatusResponse advertises the sender's current chain tip.
+type StatusResponse struct {
+	Height          uint64
+	Hash            common.Hash
+	TotalDifficulty uint64
+}
+
+func (sm *SyncManager) handleStatusRequest(peerID string, req *StatusRequest) {
+	height, hash, totalDifficulty := sm.localStatus()
+	resp := StatusResponse{Height: height, Hash: hash, TotalDifficulty: totalDifficulty}
+	sm.dispatcher.SendData([]string{peerID}, resp)
+}
+
+func (sm *SyncManager) handleStatusResponse(peerID string, resp *StatusResponse) {
+	p := sm.peerTable.GetPeer(peerID)
+	if p == nil {
+		return
+	}
+	p.SetChainStatus(resp.Height, resp.Hash)
+	p.SetTotalDifficulty(resp.TotalDifficulty)
+}
+
+// localStatus returns the height/hash/total difficulty of the highest block
+// this node has processed, i.e. what it should advertise to peers. Nothing
+// sets localTotalDifficulty, so the total difficulty returned is always zero.
+func (sm *SyncManager) localStatus() (uint64, common.Hash, uint64) {
+	sm.statusMutex.Lock()
+	defer sm.statusMutex.Unlock()
+
+	return sm.localHeight, sm.localHash, sm.localTotalDifficulty
+}
+
+// updateLocalStatus records a newly observed chain tip, ignoring it if it's
+// shorter than what's already recorded.
+func (sm *SyncManager) updateLocalStatus(height uint64, hash common.Hash) {
+	sm.statusMutex.Lock()
+	defer sm.statusMutex.Unlock()
+
+	if height < sm.localHeight {
+		return
+	}
+	sm.localHeight = height
+	sm.localHash = hash
+}
+
+// broadcastStatus announces this node's chain tip to every connected peer
+// subscribed to ChannelIDStatus.
+func (sm *SyncManager) broadcastStatus() {
+	height, hash, totalDifficulty := sm.localStatus()
+	resp := StatusResponse{Height: height, Hash: hash, TotalDifficulty: totalDifficulty}
+
+	selection := sm.peerTable.GetBroadcastSelectionFull(common.ChannelIDStatus, []string{})
+	if len(selection) == 0 {
+		return
+	}
+	peerIDs := make([]string, len(selection))
+	for i, p := range selection {
+		peerIDs[i] = p.ID()
+	}
+	sm.logger.WithFields(log.Fields{
+		"height": height,
+		"peers":  len(peerIDs),
+	}).Debug("Gossiping chain status")
+	sm.dispatcher.SendData(peerIDs, resp)
+}
+
+// pullStatusFromUnknownPeers sends a StatusRequest to every connected peer
+// whose chain status we don't know yet, so a newly-connected (or just
+// reconnected) peer's tip is pulled on demand rather than waiting up to
+// statusGossipInterval for its next periodic push.
+func (sm *SyncManager) pullStatusFromUnknownPeers() {
+	for _, p := range *sm.peerTable.GetAllPeers() {
+		if !p.LastStatusAt().IsZero() {
+			continue
+		}
+		sm.dispatcher.SendData([]string{p.ID()}, StatusRequest{})
+	}
+}
+
+// statusGossipLoop periodically announces this node's chain tip to its peers
+// and pulls the tip of any peer whose status isn't known yet.
+func (sm *SyncManager) statusGossipLoop() {
+	defer sm.wg.Done()
+
+	gossipTicker := time.NewTicker(statusGossipInterval)
+	defer gossipTicker.Stop()
+
+	pullTicker := time.NewTicker(statusPullInterval)
+	defer pullTicker.Stop()
+
+	for {
+		select {
+		case <-sm.ctx.Done():
+			return
+		case <-gossipTicker.C:
+			sm.broadcastStatus()
+		case <-pullTicker.C:
+			sm.pullStatusFromUnknownPeers()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package netsync
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/thetatoken/ukulele/common"
+	"github.com/thetatoken/ukulele/p2p/peer"
+)
+
+func newTestSyncManager(peers ...*peer.Peer) *SyncManager {
+	pt := peer.CreatePeerTable()
+	for _, p := range peers {
+		pt.AddPeer(p)
+	}
+	return &SyncManager{
+		peerTable:   &pt,
+		statusMutex: &sync.Mutex{},
+	}
+}
+
+// TestUpdateLocalStatusIgnoresStaleHeight verifies that a shorter,
+// out-of-order status update can't regress the locally recorded chain tip.
+func TestUpdateLocalStatusIgnoresStaleHeight(t *testing.T) {
+	sm := newTestSyncManager()
+
+	sm.updateLocalStatus(10, common.Hash{1})
+	sm.updateLocalStatus(5, common.Hash{2})
+
+	height, hash, _ := sm.localStatus()
+	if height != 10 {
+		t.Fatalf("height = %d, want 10 (stale update must be ignored)", height)
+	}
+	if !bytes.Equal(hash, common.Hash{1}) {
+		t.Fatalf("hash overwritten by a stale update")
+	}
+}
+
+// TestUpdateLocalStatusAcceptsTallerHeight verifies that a taller status
+// update does replace the recorded chain tip.
+func TestUpdateLocalStatusAcceptsTallerHeight(t *testing.T) {
+	sm := newTestSyncManager()
+
+	sm.updateLocalStatus(10, common.Hash{1})
+	sm.updateLocalStatus(20, common.Hash{2})
+
+	height, hash, _ := sm.localStatus()
+	if height != 20 {
+		t.Fatalf("height = %d, want 20", height)
+	}
+	if !bytes.Equal(hash, common.Hash{2}) {
+		t.Fatalf("hash = %v, want updated hash", hash)
+	}
+}
+
+// TestHandleStatusResponseUpdatesPeer verifies that an incoming
+// StatusResponse is recorded on the sending peer's chain status.
+func TestHandleStatusResponseUpdatesPeer(t *testing.T) {
+	p := peer.CreatePeer("peer1", nil, true)
+	sm := newTestSyncManager(p)
+
+	sm.handleStatusResponse("peer1", &StatusResponse{
+		Height:          42,
+		Hash:            common.Hash{9},
+		TotalDifficulty: 7,
+	})
+
+	if p.BestHeight() != 42 {
+		t.Fatalf("BestHeight() = %d, want 42", p.BestHeight())
+	}
+	if !bytes.Equal(p.BestBlockHash(), common.Hash{9}) {
+		t.Fatalf("BestBlockHash() = %v, want [9]", p.BestBlockHash())
+	}
+}
+
+// TestHandleStatusResponseIgnoresUnknownPeer verifies that a StatusResponse
+// from a peer not in the table is silently dropped rather than panicking.
+func TestHandleStatusResponseIgnoresUnknownPeer(t *testing.T) {
+	sm := newTestSyncManager()
+
+	sm.handleStatusResponse("ghost", &StatusResponse{Height: 1})
+}
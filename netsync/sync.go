@@ -12,6 +12,7 @@ import (
 	"github.com/thetatoken/ukulele/core"
 	"github.com/thetatoken/ukulele/dispatcher"
 	"github.com/thetatoken/ukulele/p2p"
+	"github.com/thetatoken/ukulele/p2p/peer"
 	"github.com/thetatoken/ukulele/rlp"
 
 	p2ptypes "github.com/thetatoken/ukulele/p2p/types"
@@ -20,6 +21,15 @@ import (
 	"github.com/spf13/viper"
 )
 
+// Misbehavior severities reported to the PeerTable. Malformed/invalid data
+// coming straight off the wire is penalized harder than a bad request, since
+// it's more likely to indicate a hostile or broken peer.
+const (
+	misbehaviorSeverityMalformedHex   = 10
+	misbehaviorSeverityInvalidPayload = 20
+	misbehaviorSeverityInvalidBlock   = 50
+)
+
 type MessageConsumer interface {
 	AddMessage(interface{})
 }
@@ -33,6 +43,7 @@ type SyncManager struct {
 	consensus  core.ConsensusEngine
 	consumer   MessageConsumer
 	dispatcher *dispatcher.Dispatcher
+	peerTable  *peer.PeerTable
 	requestMgr *RequestManager
 
 	wg      *sync.WaitGroup
@@ -42,22 +53,32 @@ type SyncManager struct {
 
 	incoming chan p2ptypes.Message
 
+	statusMutex          *sync.Mutex
+	localHeight          uint64
+	localHash            common.Hash
+	localTotalDifficulty uint64
+
 	logger *log.Entry
 }
 
-func NewSyncManager(chain *blockchain.Chain, cons core.ConsensusEngine, network p2p.Network, disp *dispatcher.Dispatcher, consumer MessageConsumer) *SyncManager {
+func NewSyncManager(chain *blockchain.Chain, cons core.ConsensusEngine, network p2p.Network, disp *dispatcher.Dispatcher, ptbl *peer.PeerTable, consumer MessageConsumer) *SyncManager {
 	sm := &SyncManager{
 		chain:      chain,
 		consensus:  cons,
 		consumer:   consumer,
 		dispatcher: disp,
+		peerTable:  ptbl,
 
 		wg:       &sync.WaitGroup{},
 		incoming: make(chan p2ptypes.Message, viper.GetInt(common.CfgSyncMessageQueueSize)),
+
+		statusMutex: &sync.Mutex{},
 	}
 	sm.requestMgr = NewRequestManager(sm)
 	network.RegisterMessageHandler(sm)
 
+	ptbl.SetBroadcastFanoutParams(viper.GetInt(common.CfgP2PBroadcastMinFanout), viper.GetFloat64(common.CfgP2PBroadcastFactor))
+
 	logger := util.GetLoggerForModule("sync")
 	if viper.GetBool(common.CfgLogPrintSelfID) {
 		logger = logger.WithFields(log.Fields{"id": sm.consensus.ID()})
@@ -72,10 +93,22 @@ func (sm *SyncManager) Start(ctx context.Context) {
 	sm.ctx = c
 	sm.cancel = cancel
 
+	if dir := viper.GetString(common.CfgP2PPersistenceDir); dir != "" {
+		if err := sm.peerTable.StartPersistence(c, dir); err != nil {
+			sm.logger.WithFields(log.Fields{
+				"dir":   dir,
+				"error": err,
+			}).Warn("Failed to start peer table persistence")
+		}
+	}
+
 	sm.requestMgr.Start(c)
 
 	sm.wg.Add(1)
 	go sm.mainLoop()
+
+	sm.wg.Add(1)
+	go sm.statusGossipLoop()
 }
 
 func (sm *SyncManager) Stop() {
@@ -103,14 +136,15 @@ func (sm *SyncManager) mainLoop() {
 	}
 }
 
-// GetChannelIDs implements the p2p.MessageHandler interface.
+// GetChannelIDs implements the p2p.MessageHandler interface. Consensus
+// traffic (proposals/votes/CCs) is handled by consensusmgr.ConsensusManager,
+// which registers as its own p2p.MessageHandler against the same PeerTable;
+// SyncManager keeps only block/inventory/status traffic.
 func (sm *SyncManager) GetChannelIDs() []common.ChannelIDEnum {
 	return []common.ChannelIDEnum{
 		common.ChannelIDHeader,
 		common.ChannelIDBlock,
-		common.ChannelIDProposal,
-		common.ChannelIDCC,
-		common.ChannelIDVote,
+		common.ChannelIDStatus,
 	}
 }
 
@@ -147,6 +181,10 @@ func (sm *SyncManager) processMessage(message p2ptypes.Message) {
 		sm.handleDataRequest(message.PeerID, &content)
 	case dispatcher.DataResponse:
 		sm.handleDataResponse(message.PeerID, &content)
+	case StatusRequest:
+		sm.handleStatusRequest(message.PeerID, &content)
+	case StatusResponse:
+		sm.handleStatusResponse(message.PeerID, &content)
 	default:
 		sm.logger.WithFields(log.Fields{
 			"message": message,
@@ -238,6 +276,7 @@ func (m *SyncManager) handleInvResponse(peerID string, resp *dispatcher.Inventor
 			hash, err := hex.DecodeString(hashStr)
 			if err != nil {
 				m.logger.WithFields(log.Fields{"channelID": resp.ChannelID, "hashStr": hashStr, "err": err}).Error("Failed to parse hash string in InvResponse")
+				m.peerTable.ReportMisbehavior(peerID, peer.MisbehaviorMalformedHex, misbehaviorSeverityMalformedHex)
 				return
 			}
 			m.requestMgr.AddHash(hash, []string{peerID})
@@ -307,33 +346,18 @@ func (m *SyncManager) handleDataResponse(peerID string, data *dispatcher.DataRes
 				"payload":   data.Payload,
 				"error":     err,
 			}).Error("Failed to decode DataResponse payload")
+			m.peerTable.ReportMisbehavior(peerID, peer.MisbehaviorInvalidPayload, misbehaviorSeverityInvalidPayload)
 			return
 		}
-		m.handleBlock(block)
-	case common.ChannelIDVote:
-		vote := &core.Vote{}
-		err := rlp.DecodeBytes(data.Payload, vote)
-		if err != nil {
-			m.logger.WithFields(log.Fields{
-				"channelID": data.ChannelID,
-				"payload":   data.Payload,
-				"error":     err,
-			}).Error("Failed to decode DataResponse payload")
-			return
-		}
-		m.handleVote(vote)
-	case common.ChannelIDProposal:
-		proposal := &core.Proposal{}
-		err := rlp.DecodeBytes(data.Payload, proposal)
-		if err != nil {
+		if !blockHashAndParentValid(block) {
 			m.logger.WithFields(log.Fields{
-				"channelID": data.ChannelID,
-				"payload":   data.Payload,
-				"error":     err,
-			}).Error("Failed to decode DataResponse payload")
+				"block.Hash":   block.Hash,
+				"block.Parent": block.Parent,
+			}).Error("Received block with invalid hash/parent")
+			m.peerTable.ReportMisbehavior(peerID, peer.MisbehaviorInvalidBlock, misbehaviorSeverityInvalidBlock)
 			return
 		}
-		m.handleProposal(proposal)
+		m.handleBlock(peerID, block)
 	default:
 		m.logger.WithFields(log.Fields{
 			"channelID": data.ChannelID,
@@ -341,31 +365,94 @@ func (m *SyncManager) handleDataResponse(peerID string, data *dispatcher.DataRes
 	}
 }
 
-func (sm *SyncManager) handleProposal(p *core.Proposal) {
-	if p.CommitCertificate != nil {
-		sm.handleCC(p.CommitCertificate)
+// blockHashAndParentValid rejects a block received from a peer whose claimed
+// Hash doesn't actually match its contents, or whose Parent is incoherent
+// (missing, or pointing at itself), before the block is handed off to the
+// request manager/consensus engine. A non-emptiness check alone would let a
+// peer get away with sending a well-formed-looking block carrying a
+// fabricated hash/parent. The hash comparison defers to block.CalculateHash,
+// the block's own canonical hash function, rather than reimplementing
+// hashing here: core knows what subset of the block it actually commits to,
+// and getting that wrong would reject every legitimate block from every
+// peer.
+func blockHashAndParentValid(block *core.Block) bool {
+	if len(block.Hash) == 0 || len(block.Parent) == 0 {
+		return false
 	}
-	sm.handleBlock(p.Block)
+	if bytes.Equal(block.Hash, block.Parent) {
+		return false
+	}
+	return bytes.Equal(block.Hash, block.CalculateHash())
 }
 
-func (sm *SyncManager) handleBlock(block *core.Block) {
+func (sm *SyncManager) handleBlock(peerID string, block *core.Block) {
 	sm.logger.WithFields(log.Fields{
 		"block.Hash":   block.Hash,
 		"block.Parent": block.Parent,
 	}).Debug("Received block")
 
 	sm.requestMgr.AddBlock(block)
+	sm.relayInventory(common.ChannelIDBlock, hex.EncodeToString(block.Hash), peerID)
+
+	if p := sm.peerTable.GetPeer(peerID); p != nil {
+		p.SetChainStatus(block.Height, block.Hash)
+	}
+	sm.updateLocalStatus(block.Height, block.Hash)
+}
+
+// FetchBlock requests the block with the given hash from peers, if it is
+// not already known locally. It satisfies consensusmgr.BlockFetcher, letting
+// the consensus manager backfill a block referenced by an incoming vote or
+// proposal without depending on RequestManager directly.
+func (sm *SyncManager) FetchBlock(hash common.Hash) {
+	if _, err := sm.chain.FindBlock(hash); err == nil {
+		return
+	}
+	sm.requestMgr.AddHash(hash, []string{})
 }
 
-func (sm *SyncManager) handleCC(cc *core.CommitCertificate) {
-	for _, vote := range cc.Votes.Votes() {
-		sm.consumer.AddMessage(&vote)
+// PickSyncPeer selects the best peer to request chain data from: the
+// tallest known peer, excluding any already-tried peers. It's used by the
+// request manager to pick an initial sync partner and to fall back to a
+// different peer when a request to BestPeer() times out, instead of
+// broadcasting inventory requests to the entire peer set.
+func (sm *SyncManager) PickSyncPeer(exclude []string) *peer.Peer {
+	excludeSet := make(map[string]bool, len(exclude))
+	for _, peerID := range exclude {
+		excludeSet[peerID] = true
 	}
+
+	if best := sm.peerTable.BestPeer(); best != nil && !excludeSet[best.ID()] {
+		return best
+	}
+
+	var picked *peer.Peer
+	candidates := sm.peerTable.PeersAboveHeight(0)
+	for _, p := range candidates {
+		if excludeSet[p.ID()] {
+			continue
+		}
+		if picked == nil || p.BestHeight() > picked.BestHeight() {
+			picked = p
+		}
+	}
+	return picked
 }
 
-func (sm *SyncManager) handleVote(vote *core.Vote) {
-	if vote.Block != nil {
-		sm.requestMgr.AddHash(vote.Block.Hash, []string{})
+// relayInventory fans the given hash out to a fractional, sqrt(N)-sized
+// selection of peers subscribed to channelID, excluding the peer the
+// message was received from. This is safe for gossip-style inventory
+// (blocks), where a peer that misses the relay can still catch up via
+// sync requests.
+func (sm *SyncManager) relayInventory(channelID common.ChannelIDEnum, hash string, fromPeerID string) {
+	selection := sm.peerTable.GetBroadcastSelection(channelID, []string{fromPeerID})
+	if len(selection) == 0 {
+		return
+	}
+	peerIDs := make([]string, len(selection))
+	for i, p := range selection {
+		peerIDs[i] = p.ID()
 	}
-	sm.consumer.AddMessage(vote)
+	resp := dispatcher.InventoryResponse{ChannelID: channelID, Entries: []string{hash}}
+	sm.dispatcher.SendInventory(peerIDs, resp)
 }
@@ -0,0 +1,382 @@
+package peer
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	nu "github.com/thetatoken/theta/p2p/netutil"
+)
+
+const (
+	// triedBucketCount/newBucketCount mirror Bitcoin/neo-go's addrman split:
+	// addresses we've successfully handshaken with live in `tried`, addresses
+	// we've only heard about via gossip live in `new`.
+	triedBucketCount = 64
+	newBucketCount   = 256
+
+	// bucketSize caps how many addresses a single bucket can hold before an
+	// incoming address forces an eviction.
+	bucketSize = 32
+
+	addressBookFileName = "peers.json"
+)
+
+// addrInfo is a single entry in the address book.
+type addrInfo struct {
+	Addr *nu.NetAddress `json:"addr"`
+	Src  *nu.NetAddress `json:"src"`
+
+	Tried  bool `json:"tried"`
+	IsSeed bool `json:"is_seed"`
+
+	LastSeen    time.Time `json:"last_seen"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+	Attempts    int       `json:"attempts"`
+}
+
+// AddressBook is a Bitcoin/neo-go style persistent store of peer addresses,
+// split into `tried` (successfully handshaken) and `new` (gossiped but
+// unverified) buckets so that a restart or a flood of low-quality gossip
+// doesn't crowd out addresses we know are reachable.
+type AddressBook struct {
+	mutex *sync.Mutex
+
+	tried [triedBucketCount][]*addrInfo
+	new   [newBucketCount][]*addrInfo
+
+	byAddr map[string]*addrInfo // addr.String() |-> addrInfo, across both bucket sets
+
+	filePath string
+}
+
+// NewAddressBook creates an AddressBook, loading any existing peers.json
+// found at filePath (filePath may be empty, in which case the address book
+// operates purely in-memory until SetFilePath is called).
+func NewAddressBook(filePath string) *AddressBook {
+	ab := &AddressBook{
+		mutex:    &sync.Mutex{},
+		byAddr:   make(map[string]*addrInfo),
+		filePath: filePath,
+	}
+	if err := ab.load(); err != nil {
+		logger.Warnf("Failed to load address book from %v: %v", filePath, err)
+	}
+	return ab
+}
+
+// SetFilePath points the address book at a peers.json path to persist to,
+// loading any entries already present there.
+func (ab *AddressBook) SetFilePath(filePath string) error {
+	ab.mutex.Lock()
+	ab.filePath = filePath
+	ab.mutex.Unlock()
+
+	return ab.load()
+}
+
+// groupKey buckets addresses by their /16 IPv4 network (or their bare host
+// string for anything else), matching how Bitcoin/neo-go group addresses to
+// avoid a single host/subnet dominating a bucket.
+func groupKey(addr *nu.NetAddress) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return host
+}
+
+func bucketIndex(numBuckets int, group string, sourceGroup string) int {
+	h := fnv.New64a()
+	h.Write([]byte(group))
+	h.Write([]byte{0})
+	h.Write([]byte(sourceGroup))
+	return int(h.Sum64() % uint64(numBuckets))
+}
+
+// AddAddress adds addr (learned about via src) to the `new` bucket if it's
+// not already known anywhere in the address book.
+func (ab *AddressBook) AddAddress(addr *nu.NetAddress, src *nu.NetAddress) {
+	if addr == nil {
+		return
+	}
+
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	key := addr.String()
+	if _, exists := ab.byAddr[key]; exists {
+		return
+	}
+
+	info := &addrInfo{Addr: addr, Src: src, LastSeen: time.Now()}
+	ab.byAddr[key] = info
+
+	idx := bucketIndex(newBucketCount, groupKey(addr), groupKey(src))
+	ab.new[idx] = evictIfFull(ab.new[idx], ab.byAddr)
+	ab.new[idx] = append(ab.new[idx], info)
+}
+
+// MarkAttempt records a connection attempt to addr, successful or not.
+func (ab *AddressBook) MarkAttempt(addr *nu.NetAddress) {
+	if addr == nil {
+		return
+	}
+
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	info, exists := ab.byAddr[addr.String()]
+	if !exists {
+		return
+	}
+	info.LastAttempt = time.Now()
+	info.Attempts++
+}
+
+// MarkGood promotes addr to the `tried` bucket after a successful handshake.
+func (ab *AddressBook) MarkGood(addr *nu.NetAddress) {
+	if addr == nil {
+		return
+	}
+
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	info, exists := ab.byAddr[addr.String()]
+	if !exists {
+		info = &addrInfo{Addr: addr}
+		ab.byAddr[addr.String()] = info
+	}
+	info.LastSuccess = time.Now()
+	info.Attempts = 0
+
+	if info.Tried {
+		return
+	}
+	info.Tried = true
+
+	ab.removeFromBucketSet(ab.new[:], addr.String())
+
+	idx := bucketIndex(triedBucketCount, groupKey(addr), groupKey(info.Src))
+	ab.tried[idx] = evictIfFull(ab.tried[idx], ab.byAddr)
+	ab.tried[idx] = append(ab.tried[idx], info)
+}
+
+// MarkSeed flags addr as a seed address, exempting it from bucket eviction
+// regardless of how stale it becomes.
+func (ab *AddressBook) MarkSeed(addr *nu.NetAddress) {
+	if addr == nil {
+		return
+	}
+
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	info, exists := ab.byAddr[addr.String()]
+	if !exists {
+		info = &addrInfo{Addr: addr, LastSeen: time.Now()}
+		ab.byAddr[addr.String()] = info
+	}
+	info.IsSeed = true
+}
+
+func (ab *AddressBook) removeFromBucketSet(buckets [][]*addrInfo, addrStr string) {
+	for i, bucket := range buckets {
+		for j, info := range bucket {
+			if info.Addr.String() == addrStr {
+				buckets[i] = append(bucket[:j], bucket[j+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// evictIfFull evicts the stalest entry (oldest LastSuccess, falling back to
+// LastSeen) from bucket if it's already at capacity, making room for a new
+// entry. Seed addresses are never evicted; if every entry in the bucket is a
+// seed, evictIfFull refuses to evict and returns bucket unchanged.
+func evictIfFull(bucket []*addrInfo, byAddr map[string]*addrInfo) []*addrInfo {
+	if len(bucket) < bucketSize {
+		return bucket
+	}
+
+	oldestIdx := -1
+	var oldest time.Time
+	for i, info := range bucket {
+		if info.IsSeed {
+			continue
+		}
+		ts := bucketTimestamp(info)
+		if oldestIdx == -1 || ts.Before(oldest) {
+			oldest = ts
+			oldestIdx = i
+		}
+	}
+	if oldestIdx == -1 {
+		return bucket
+	}
+
+	delete(byAddr, bucket[oldestIdx].Addr.String())
+	return append(bucket[:oldestIdx], bucket[oldestIdx+1:]...)
+}
+
+func bucketTimestamp(info *addrInfo) time.Time {
+	if !info.LastSuccess.IsZero() {
+		return info.LastSuccess
+	}
+	return info.LastSeen
+}
+
+// SelectAddress picks a random address, drawing from the `tried` bucket set
+// with probability biasTowardTried and from `new` otherwise. If the chosen
+// bucket set is empty it falls back to the other one; returns nil only if
+// both are empty.
+func (ab *AddressBook) SelectAddress(biasTowardTried float64) *nu.NetAddress {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	buckets := ab.new[:]
+	if rand.Float64() < biasTowardTried {
+		buckets = ab.tried[:]
+	}
+
+	info := randomFromBuckets(buckets)
+	if info == nil {
+		// Fall back to whichever set is non-empty.
+		info = randomFromBuckets(ab.tried[:])
+		if info == nil {
+			info = randomFromBuckets(ab.new[:])
+		}
+	}
+	if info == nil {
+		return nil
+	}
+	return info.Addr
+}
+
+func randomFromBuckets(buckets [][]*addrInfo) *addrInfo {
+	nonEmpty := make([][]*addrInfo, 0, len(buckets))
+	for _, bucket := range buckets {
+		if len(bucket) > 0 {
+			nonEmpty = append(nonEmpty, bucket)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return nil
+	}
+	bucket := nonEmpty[rand.Intn(len(nonEmpty))]
+	return bucket[rand.Intn(len(bucket))]
+}
+
+// GetSelection draws a randomized sample of addresses proportionally from
+// both the `tried` and `new` bucket sets.
+func (ab *AddressBook) GetSelection(numAddrs int) []*nu.NetAddress {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	var all []*addrInfo
+	for _, bucket := range ab.tried {
+		all = append(all, bucket...)
+	}
+	for _, bucket := range ab.new {
+		all = append(all, bucket...)
+	}
+	if len(all) == 0 {
+		return nil
+	}
+	if numAddrs > len(all) {
+		numAddrs = len(all)
+	}
+
+	rand.Shuffle(len(all), func(i, j int) { all[i], all[j] = all[j], all[i] })
+
+	selection := make([]*nu.NetAddress, numAddrs)
+	for i := 0; i < numAddrs; i++ {
+		selection[i] = all[i].Addr
+	}
+	return selection
+}
+
+// Size returns the total number of addresses known across both bucket sets.
+func (ab *AddressBook) Size() int {
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	return len(ab.byAddr)
+}
+
+// Save persists the address book to its configured file path as JSON.
+func (ab *AddressBook) Save() error {
+	if ab.filePath == "" {
+		return nil
+	}
+
+	ab.mutex.Lock()
+	var all []*addrInfo
+	for _, bucket := range ab.tried {
+		all = append(all, bucket...)
+	}
+	for _, bucket := range ab.new {
+		all = append(all, bucket...)
+	}
+	ab.mutex.Unlock()
+
+	raw, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(ab.filePath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(ab.filePath, raw, 0644)
+}
+
+func (ab *AddressBook) load() error {
+	if ab.filePath == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(ab.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var all []*addrInfo
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return err
+	}
+
+	ab.mutex.Lock()
+	defer ab.mutex.Unlock()
+
+	for _, info := range all {
+		ab.byAddr[info.Addr.String()] = info
+		if info.Tried {
+			idx := bucketIndex(triedBucketCount, groupKey(info.Addr), groupKey(info.Src))
+			ab.tried[idx] = append(ab.tried[idx], info)
+		} else {
+			idx := bucketIndex(newBucketCount, groupKey(info.Addr), groupKey(info.Src))
+			ab.new[idx] = append(ab.new[idx], info)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package peer
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	nu "github.com/thetatoken/theta/p2p/netutil"
+)
+
+func testNetAddress(port uint16) *nu.NetAddress {
+	return &nu.NetAddress{IP: net.ParseIP("127.0.0.1"), Port: port}
+}
+
+// TestEvictIfFullSkipsSeeds verifies that evictIfFull never picks a seed
+// entry, even when it's the stalest one in a full bucket.
+func TestEvictIfFullSkipsSeeds(t *testing.T) {
+	byAddr := make(map[string]*addrInfo)
+
+	bucket := make([]*addrInfo, 0, bucketSize)
+	for i := 0; i < bucketSize; i++ {
+		info := &addrInfo{
+			Addr:     &nu.NetAddress{},
+			LastSeen: time.Now().Add(time.Duration(i) * time.Minute),
+		}
+		bucket = append(bucket, info)
+	}
+
+	// The very stalest entry in the bucket is a seed; it must survive
+	// eviction even though it would otherwise be the natural pick.
+	seed := bucket[0]
+	seed.IsSeed = true
+	seed.LastSeen = time.Now().Add(-24 * time.Hour)
+
+	// With the seed skipped, the stalest remaining entry is bucket[1].
+	stalestNonSeed := bucket[1]
+
+	result := evictIfFull(bucket, byAddr)
+
+	if len(result) != len(bucket)-1 {
+		t.Fatalf("expected evictIfFull to evict exactly one entry, got %d -> %d", len(bucket), len(result))
+	}
+	for _, info := range result {
+		if info == stalestNonSeed {
+			t.Fatalf("evictIfFull kept the stalest non-seed entry instead of evicting it")
+		}
+	}
+	found := false
+	for _, info := range result {
+		if info.IsSeed {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("seed entry was evicted from the bucket, it must never be")
+	}
+}
+
+// TestEvictIfFullAllSeedsRefusesToEvict verifies that a bucket made entirely
+// of seed addresses is left untouched rather than evicting a seed to make
+// room.
+func TestEvictIfFullAllSeedsRefusesToEvict(t *testing.T) {
+	byAddr := make(map[string]*addrInfo)
+
+	bucket := make([]*addrInfo, 0, bucketSize)
+	for i := 0; i < bucketSize; i++ {
+		info := &addrInfo{
+			Addr:     &nu.NetAddress{},
+			IsSeed:   true,
+			LastSeen: time.Now().Add(time.Duration(i) * time.Minute),
+		}
+		bucket = append(bucket, info)
+	}
+
+	result := evictIfFull(bucket, byAddr)
+
+	if len(result) != len(bucket) {
+		t.Fatalf("expected an all-seed bucket to be left unchanged, got len %d, want %d", len(result), len(bucket))
+	}
+}
+
+// TestAddressBookRoundTrip exercises AddAddress, MarkGood, SelectAddress,
+// GetSelection, Save, and loading a fresh AddressBook from the saved file,
+// verifying that a tried address survives the round trip.
+func TestAddressBookRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "addressbook_test")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, addressBookFileName)
+	ab := NewAddressBook(filePath)
+
+	addr := testNetAddress(10001)
+	src := testNetAddress(10002)
+	ab.AddAddress(addr, src)
+	ab.MarkGood(addr)
+
+	if got := ab.Size(); got != 1 {
+		t.Fatalf("Size() = %d, want 1", got)
+	}
+
+	selected := ab.SelectAddress(1.0)
+	if selected == nil || selected.String() != addr.String() {
+		t.Fatalf("SelectAddress(1.0) = %v, want %v (the only, tried address)", selected, addr)
+	}
+
+	selection := ab.GetSelection(10)
+	if len(selection) != 1 || selection[0].String() != addr.String() {
+		t.Fatalf("GetSelection(10) = %v, want [%v]", selection, addr)
+	}
+
+	if err := ab.Save(); err != nil {
+		t.Fatalf("Save() failed: %v", err)
+	}
+
+	reloaded := NewAddressBook(filePath)
+	if got := reloaded.Size(); got != 1 {
+		t.Fatalf("after reload, Size() = %d, want 1", got)
+	}
+	reselected := reloaded.SelectAddress(1.0)
+	if reselected == nil || reselected.String() != addr.String() {
+		t.Fatalf("after reload, SelectAddress(1.0) = %v, want %v", reselected, addr)
+	}
+}
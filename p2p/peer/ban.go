@@ -0,0 +1,255 @@
+package peer
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	nu "github.com/thetatoken/theta/p2p/netutil"
+)
+
+// persistenceInterval is how often the address book is flushed to disk.
+const persistenceInterval = 1 * time.Minute
+
+// MisbehaviorReason categorizes why a peer's misbehavior score was docked.
+type MisbehaviorReason string
+
+const (
+	MisbehaviorInvalidBlock    MisbehaviorReason = "invalid_block"
+	MisbehaviorInvalidPayload  MisbehaviorReason = "invalid_payload"
+	MisbehaviorMalformedHex    MisbehaviorReason = "malformed_hex"
+	MisbehaviorUnsolicitedData MisbehaviorReason = "unsolicited_data"
+)
+
+const (
+	// defaultBanThreshold is the misbehavior score past which a peer is
+	// disconnected and banned.
+	defaultBanThreshold = 100
+
+	// defaultBanDuration is how long a banned address is rejected for.
+	defaultBanDuration = 24 * time.Hour
+
+	banListFileName = "ban_list.json"
+)
+
+// banEntry records when a banned address's ban expires.
+type banEntry struct {
+	Addr      string    `json:"addr"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// setPersistenceDir points the PeerTable at a directory to persist its ban
+// list and address book to, and loads any state already present there.
+func (pt *PeerTable) setPersistenceDir(dir string) error {
+	pt.mutex.Lock()
+	pt.persistenceDir = dir
+	addrBook := pt.addrBook
+	pt.mutex.Unlock()
+
+	if err := addrBook.SetFilePath(filepath.Join(dir, addressBookFileName)); err != nil {
+		return err
+	}
+
+	return pt.loadBanList()
+}
+
+// SetBanThreshold overrides the default misbehavior score threshold at which
+// a peer gets banned.
+func (pt *PeerTable) SetBanThreshold(threshold int) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.banThreshold = threshold
+}
+
+// ReportMisbehavior docks the peer's misbehavior score by severity. Once the
+// accumulated score crosses the ban threshold, the peer is stopped, removed
+// from the table, and its address is banned.
+func (pt *PeerTable) ReportMisbehavior(peerID string, reason MisbehaviorReason, severity int) {
+	pt.mutex.Lock()
+	peer, exists := pt.peerMap[peerID]
+	if !exists {
+		pt.mutex.Unlock()
+		return
+	}
+
+	pt.misbehaviorScores[peerID] += severity
+	score := pt.misbehaviorScores[peerID]
+	threshold := pt.banThreshold
+	pt.mutex.Unlock()
+
+	logger.WithFields(log.Fields{
+		"peerID":   peerID,
+		"reason":   reason,
+		"severity": severity,
+		"score":    score,
+	}).Warn("Peer misbehavior reported")
+
+	if score <= threshold {
+		return
+	}
+
+	logger.Warnf("Peer %v exceeded misbehavior threshold (score=%v), disconnecting and banning", peerID, score)
+
+	netAddress := peer.NetAddress()
+	pt.DeletePeer(peerID)
+	peer.Stop()
+
+	if netAddress != nil {
+		pt.banAddress(netAddress, defaultBanDuration)
+	}
+}
+
+// AddBannedPeer bans addr for the given duration, stopping and removing the
+// corresponding peer from the table if it is currently connected. Unlike the
+// auto-ban path in ReportMisbehavior, this is meant for an operator banning
+// an address identified after the fact (e.g. from logs), so it takes effect
+// whether or not that address is currently connected.
+func (pt *PeerTable) AddBannedPeer(addr *nu.NetAddress, duration time.Duration) {
+	if addr == nil {
+		return
+	}
+
+	pt.mutex.Lock()
+	var peer *Peer
+	for _, p := range pt.peers {
+		if p.NetAddress() != nil && p.NetAddress().String() == addr.String() {
+			peer = p
+			break
+		}
+	}
+	pt.mutex.Unlock()
+
+	if peer != nil {
+		pt.DeletePeer(peer.ID())
+		peer.Stop()
+	}
+
+	pt.banAddress(addr, duration)
+}
+
+// IsBanned indicates whether the given address is currently banned.
+func (pt *PeerTable) IsBanned(addr *nu.NetAddress) bool {
+	if addr == nil {
+		return false
+	}
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	entry, exists := pt.banList[addr.String()]
+	if !exists {
+		return false
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		delete(pt.banList, addr.String())
+		return false
+	}
+	return true
+}
+
+// banAddress records a ban for addr and persists the updated ban list.
+func (pt *PeerTable) banAddress(addr *nu.NetAddress, duration time.Duration) {
+	pt.mutex.Lock()
+	pt.banList[addr.String()] = banEntry{
+		Addr:      addr.String(),
+		ExpiresAt: time.Now().Add(duration),
+	}
+	pt.mutex.Unlock()
+
+	if err := pt.persistBanList(); err != nil {
+		logger.Warnf("Failed to persist ban list: %v", err)
+	}
+}
+
+// StartPersistence points the PeerTable at dir to persist its ban list and
+// address book to, loads any state already present there, and periodically
+// flushes the address book to disk until ctx is canceled. This must be
+// called once a node knows its data directory (see SyncManager.Start) for
+// the ban list/address book to actually survive a restart.
+func (pt *PeerTable) StartPersistence(ctx context.Context, dir string) error {
+	if err := pt.setPersistenceDir(dir); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(persistenceInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := pt.addrBook.Save(); err != nil {
+					logger.Warnf("Failed to persist address book: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (pt *PeerTable) banListPath() string {
+	if pt.persistenceDir == "" {
+		return ""
+	}
+	return filepath.Join(pt.persistenceDir, banListFileName)
+}
+
+func (pt *PeerTable) loadBanList() error {
+	path := pt.banListPath()
+	if path == "" {
+		return nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []banEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return err
+	}
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	now := time.Now()
+	for _, entry := range entries {
+		if now.After(entry.ExpiresAt) {
+			continue
+		}
+		pt.banList[entry.Addr] = entry
+	}
+	return nil
+}
+
+func (pt *PeerTable) persistBanList() error {
+	path := pt.banListPath()
+	if path == "" {
+		return nil
+	}
+
+	pt.mutex.Lock()
+	entries := make([]banEntry, 0, len(pt.banList))
+	for _, entry := range pt.banList {
+		entries = append(entries, entry)
+	}
+	pt.mutex.Unlock()
+
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, raw, 0644)
+}
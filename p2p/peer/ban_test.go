@@ -0,0 +1,67 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	nu "github.com/thetatoken/theta/p2p/netutil"
+)
+
+// TestReportMisbehaviorBansPastThreshold verifies that a peer is stopped,
+// removed from the table, and its address banned once its misbehavior score
+// crosses the configured threshold, and that a banned address is rejected on
+// a subsequent AddPeer.
+func TestReportMisbehaviorBansPastThreshold(t *testing.T) {
+	pt := CreatePeerTable()
+	pt.SetBanThreshold(50)
+
+	addr := &nu.NetAddress{}
+	p := CreatePeer("peer1", addr, true)
+	if !pt.AddPeer(p) {
+		t.Fatalf("AddPeer rejected a fresh, unbanned peer")
+	}
+
+	pt.ReportMisbehavior("peer1", MisbehaviorInvalidBlock, 30)
+	if pt.IsBanned(addr) {
+		t.Fatalf("peer banned before crossing the threshold (score=30, threshold=50)")
+	}
+	if !pt.PeerExists("peer1") {
+		t.Fatalf("peer removed before crossing the ban threshold")
+	}
+
+	pt.ReportMisbehavior("peer1", MisbehaviorInvalidBlock, 30)
+	if !pt.IsBanned(addr) {
+		t.Fatalf("peer not banned after crossing the threshold (score=60, threshold=50)")
+	}
+	if pt.PeerExists("peer1") {
+		t.Fatalf("banned peer not removed from the table")
+	}
+
+	other := CreatePeer("peer2", addr, true)
+	if pt.AddPeer(other) {
+		t.Fatalf("AddPeer accepted a peer whose address is currently banned")
+	}
+}
+
+// TestAddBannedPeerOffline verifies that AddBannedPeer bans an address that
+// has no currently-connected peer, e.g. an operator banning an address they
+// identified from logs after it already disconnected.
+func TestAddBannedPeerOffline(t *testing.T) {
+	pt := CreatePeerTable()
+
+	addr := &nu.NetAddress{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+	if pt.IsBanned(addr) {
+		t.Fatalf("address banned before AddBannedPeer was ever called")
+	}
+
+	pt.AddBannedPeer(addr, time.Hour)
+	if !pt.IsBanned(addr) {
+		t.Fatalf("AddBannedPeer did not ban an address with no connected peer")
+	}
+
+	p := CreatePeer("peer1", addr, true)
+	if pt.AddPeer(p) {
+		t.Fatalf("AddPeer accepted a peer whose address was banned while offline")
+	}
+}
@@ -0,0 +1,114 @@
+package peer
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/thetatoken/ukulele/common"
+)
+
+const (
+	// defaultMinFanout is the minimum number of peers GetBroadcastSelection
+	// will return, regardless of broadcastFactor, as long as enough eligible
+	// peers exist.
+	defaultMinFanout = 8
+
+	// defaultBroadcastFactor scales the sqrt(N) fanout target. 1.0 reaches
+	// (approximately) every eligible peer, 0.66 reaches about two-thirds.
+	defaultBroadcastFactor = 0.66
+)
+
+// SetBroadcastFanoutParams overrides the default min fanout and broadcast
+// factor used by GetBroadcastSelection.
+func (pt *PeerTable) SetBroadcastFanoutParams(minFanout int, broadcastFactor float64) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.minFanout = minFanout
+	pt.broadcastFactor = broadcastFactor
+}
+
+// GetBroadcastSelection returns a tunable, randomized subset of the peers
+// subscribed to channelID (excluding the given peer IDs and any peer whose
+// send queue for that channel is saturated), sized to reach roughly all
+// peers within a couple of hops while cutting per-broadcast CPU/bandwidth on
+// large validator sets. The fanout target is
+// max(minFanout, ceil(sqrt(N)*broadcastFactor)), where N is the number of
+// eligible peers.
+func (pt *PeerTable) GetBroadcastSelection(channelID common.ChannelIDEnum, exclude []string) []*Peer {
+	excludeSet := make(map[string]bool, len(exclude))
+	for _, peerID := range exclude {
+		excludeSet[peerID] = true
+	}
+
+	pt.mutex.Lock()
+	minFanout := pt.minFanout
+	if minFanout == 0 {
+		minFanout = defaultMinFanout
+	}
+	broadcastFactor := pt.broadcastFactor
+	if broadcastFactor == 0 {
+		broadcastFactor = defaultBroadcastFactor
+	}
+
+	eligible := make([]*Peer, 0, len(pt.peers))
+	for _, p := range pt.peers {
+		if excludeSet[p.ID()] {
+			continue
+		}
+		if !p.HasChannel(channelID) {
+			continue
+		}
+		if p.IsSendQueueFull(channelID) {
+			continue
+		}
+		eligible = append(eligible, p)
+	}
+	pt.mutex.Unlock()
+
+	if len(eligible) == 0 {
+		return nil
+	}
+
+	fanout := int(math.Ceil(math.Sqrt(float64(len(eligible))) * broadcastFactor))
+	if fanout < minFanout {
+		fanout = minFanout
+	}
+	if fanout > len(eligible) {
+		fanout = len(eligible)
+	}
+
+	// Fisher-Yates shuffle, stopping after the first `fanout` picks since
+	// that's all we need.
+	for i := 0; i < fanout; i++ {
+		j := rand.Intn(len(eligible)-i) + i
+		eligible[i], eligible[j] = eligible[j], eligible[i]
+	}
+
+	return eligible[:fanout]
+}
+
+// GetBroadcastSelectionFull returns every peer subscribed to channelID,
+// excluding the given peer IDs. It is meant for messages that must reach
+// every peer, e.g. a validator relaying its own block proposal.
+func (pt *PeerTable) GetBroadcastSelectionFull(channelID common.ChannelIDEnum, exclude []string) []*Peer {
+	excludeSet := make(map[string]bool, len(exclude))
+	for _, peerID := range exclude {
+		excludeSet[peerID] = true
+	}
+
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	selection := make([]*Peer, 0, len(pt.peers))
+	for _, p := range pt.peers {
+		if excludeSet[p.ID()] {
+			continue
+		}
+		if !p.HasChannel(channelID) {
+			continue
+		}
+		selection = append(selection, p)
+	}
+	return selection
+}
@@ -0,0 +1,78 @@
+package peer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/thetatoken/ukulele/common"
+)
+
+func newEligiblePeer(id string) *Peer {
+	p := CreatePeer(id, nil, true)
+	p.SetChannels([]common.ChannelIDEnum{common.ChannelIDStatus})
+	return p
+}
+
+// TestGetBroadcastSelectionRespectsMinFanout verifies that with a small
+// eligible set, GetBroadcastSelection returns every eligible peer rather
+// than under-shooting minFanout.
+func TestGetBroadcastSelectionRespectsMinFanout(t *testing.T) {
+	pt := CreatePeerTable()
+	for i := 0; i < 5; i++ {
+		pt.AddPeer(newEligiblePeer(fmt.Sprintf("peer%d", i)))
+	}
+
+	selection := pt.GetBroadcastSelection(common.ChannelIDStatus, []string{})
+	if len(selection) != 5 {
+		t.Fatalf("len(selection) = %d, want 5 (all eligible peers, below minFanout)", len(selection))
+	}
+}
+
+// TestGetBroadcastSelectionCapsFanoutBelowEligibleCount verifies that once
+// the eligible set grows past minFanout, the fanout is capped well below
+// the full set, per the sqrt(N)*broadcastFactor formula.
+func TestGetBroadcastSelectionCapsFanoutBelowEligibleCount(t *testing.T) {
+	pt := CreatePeerTable()
+	pt.SetBroadcastFanoutParams(2, 1.0)
+	for i := 0; i < 100; i++ {
+		pt.AddPeer(newEligiblePeer(fmt.Sprintf("peer%d", i)))
+	}
+
+	selection := pt.GetBroadcastSelection(common.ChannelIDStatus, []string{})
+	if len(selection) == 0 || len(selection) >= 100 {
+		t.Fatalf("len(selection) = %d, want a fanout strictly between 0 and 100", len(selection))
+	}
+}
+
+// TestGetBroadcastSelectionExcludesAndFiltersBySubscription verifies that
+// excluded peer IDs and peers not subscribed to the channel are never
+// returned.
+func TestGetBroadcastSelectionExcludesAndFiltersBySubscription(t *testing.T) {
+	pt := CreatePeerTable()
+	subscribed := newEligiblePeer("subscribed")
+	unsubscribed := CreatePeer("unsubscribed", nil, true)
+	excluded := newEligiblePeer("excluded")
+
+	pt.AddPeer(subscribed)
+	pt.AddPeer(unsubscribed)
+	pt.AddPeer(excluded)
+
+	selection := pt.GetBroadcastSelection(common.ChannelIDStatus, []string{"excluded"})
+	if len(selection) != 1 || selection[0].ID() != "subscribed" {
+		t.Fatalf("selection = %v, want just [subscribed]", selection)
+	}
+}
+
+// TestGetBroadcastSelectionFullReturnsEveryEligiblePeer verifies that the
+// "reach everyone" variant isn't subject to the fanout cap.
+func TestGetBroadcastSelectionFullReturnsEveryEligiblePeer(t *testing.T) {
+	pt := CreatePeerTable()
+	for i := 0; i < 50; i++ {
+		pt.AddPeer(newEligiblePeer(fmt.Sprintf("peer%d", i)))
+	}
+
+	selection := pt.GetBroadcastSelectionFull(common.ChannelIDStatus, []string{})
+	if len(selection) != 50 {
+		t.Fatalf("len(selection) = %d, want 50 (every eligible peer)", len(selection))
+	}
+}
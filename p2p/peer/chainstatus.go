@@ -0,0 +1,167 @@
+package peer
+
+import (
+	"time"
+
+	"github.com/thetatoken/ukulele/common"
+)
+
+// chainStatus holds the chain tip a peer last advertised, either by gossiping
+// a StatusResponse or by relaying a block over the sync channels. Votes and
+// proposals received by the consensus manager do not update it.
+type chainStatus struct {
+	bestHeight      uint64
+	bestBlockHash   common.Hash
+	totalDifficulty uint64
+	latency         time.Duration
+	lastStatusAt    time.Time
+}
+
+// SetChainStatus records the peer's advertised chain tip. It's a no-op if
+// height is lower than what's already recorded, so that stale,
+// out-of-order status updates can't regress a peer's known tip.
+func (p *Peer) SetChainStatus(height uint64, hash common.Hash) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if height < p.chainStatus.bestHeight {
+		return
+	}
+	p.chainStatus.bestHeight = height
+	p.chainStatus.bestBlockHash = hash
+	p.chainStatus.lastStatusAt = time.Now()
+}
+
+// SetTotalDifficulty records the peer's advertised cumulative chain weight
+func (p *Peer) SetTotalDifficulty(totalDifficulty uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.chainStatus.totalDifficulty = totalDifficulty
+}
+
+// SetLatency records the peer's measured round-trip latency, used to break
+// ties between equally-tall peers when picking a sync partner.
+func (p *Peer) SetLatency(latency time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.chainStatus.latency = latency
+}
+
+// BestHeight returns the highest chain height this peer has advertised
+func (p *Peer) BestHeight() uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.chainStatus.bestHeight
+}
+
+// BestBlockHash returns the hash of the tip this peer has advertised
+func (p *Peer) BestBlockHash() common.Hash {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.chainStatus.bestBlockHash
+}
+
+// Latency returns the peer's last measured round-trip latency
+func (p *Peer) Latency() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.chainStatus.latency
+}
+
+// LastStatusAt returns the time at which the peer's chain status was last
+// updated
+func (p *Peer) LastStatusAt() time.Time {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.chainStatus.lastStatusAt
+}
+
+// PeerInfo is a snapshot of a peer's identity and advertised chain state,
+// suitable for RPC responses.
+type PeerInfo struct {
+	ID              string      `json:"id"`
+	Address         string      `json:"address"`
+	IsOutbound      bool        `json:"is_outbound"`
+	BestHeight      uint64      `json:"best_height"`
+	BestBlockHash   common.Hash `json:"best_block_hash"`
+	TotalDifficulty uint64      `json:"total_difficulty"`
+}
+
+// BestPeer returns the peer that has advertised the greatest chain height,
+// breaking ties in favor of the peer with the lower latency. It returns nil
+// if the table has no peers.
+func (pt *PeerTable) BestPeer() *Peer {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	var best *Peer
+	for _, p := range pt.peers {
+		if best == nil {
+			best = p
+			continue
+		}
+		bestHeight, height := best.BestHeight(), p.BestHeight()
+		if height > bestHeight {
+			best = p
+		} else if height == bestHeight && p.Latency() > 0 &&
+			(best.Latency() == 0 || p.Latency() < best.Latency()) {
+			// A zero Latency() means the peer has never had SetLatency
+			// called on it, not that it actually has zero RTT, so it must
+			// never win the tiebreak over a peer with a real measurement.
+			best = p
+		}
+	}
+	return best
+}
+
+// PeersAboveHeight returns every peer that has advertised a chain height
+// greater than or equal to h.
+func (pt *PeerTable) PeersAboveHeight(h uint64) []*Peer {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	var peers []*Peer
+	for _, p := range pt.peers {
+		if p.BestHeight() >= h {
+			peers = append(peers, p)
+		}
+	}
+	return peers
+}
+
+// GetPeerInfos returns a PeerInfo snapshot for every peer in the table,
+// suitable for RPC responses.
+func (pt *PeerTable) GetPeerInfos() []PeerInfo {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	infos := make([]PeerInfo, len(pt.peers))
+	for i, p := range pt.peers {
+		infos[i] = newPeerInfo(p)
+	}
+	return infos
+}
+
+func newPeerInfo(p *Peer) PeerInfo {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	addr := ""
+	if p.netAddress != nil {
+		addr = p.netAddress.String()
+	}
+	return PeerInfo{
+		ID:              p.id,
+		Address:         addr,
+		IsOutbound:      p.isOutbound,
+		BestHeight:      p.chainStatus.bestHeight,
+		BestBlockHash:   p.chainStatus.bestBlockHash,
+		TotalDifficulty: p.chainStatus.totalDifficulty,
+	}
+}
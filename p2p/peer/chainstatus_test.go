@@ -0,0 +1,106 @@
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thetatoken/ukulele/common"
+)
+
+// TestBestPeerPicksTallestChain verifies that BestPeer returns the peer that
+// has advertised the greatest height.
+func TestBestPeerPicksTallestChain(t *testing.T) {
+	pt := CreatePeerTable()
+
+	short := CreatePeer("short", nil, true)
+	short.SetChainStatus(10, common.Hash{1})
+	tall := CreatePeer("tall", nil, true)
+	tall.SetChainStatus(20, common.Hash{2})
+
+	pt.AddPeer(short)
+	pt.AddPeer(tall)
+
+	best := pt.BestPeer()
+	if best == nil || best.ID() != "tall" {
+		t.Fatalf("BestPeer() = %v, want tall", best)
+	}
+}
+
+// TestBestPeerBreaksTiesByLatency verifies that when two peers have
+// advertised the same height, BestPeer prefers the lower-latency one.
+func TestBestPeerBreaksTiesByLatency(t *testing.T) {
+	pt := CreatePeerTable()
+
+	slow := CreatePeer("slow", nil, true)
+	slow.SetChainStatus(10, common.Hash{1})
+	slow.SetLatency(200 * time.Millisecond)
+	fast := CreatePeer("fast", nil, true)
+	fast.SetChainStatus(10, common.Hash{1})
+	fast.SetLatency(20 * time.Millisecond)
+
+	pt.AddPeer(slow)
+	pt.AddPeer(fast)
+
+	best := pt.BestPeer()
+	if best == nil || best.ID() != "fast" {
+		t.Fatalf("BestPeer() = %v, want fast (lower latency tiebreak)", best)
+	}
+}
+
+// TestBestPeerTieUnmeasuredLatencyLoses verifies that a peer with no
+// measured latency (Latency() zero-value) never wins the tiebreak over a
+// peer with a real measurement, even though zero "looks" lowest.
+func TestBestPeerTieUnmeasuredLatencyLoses(t *testing.T) {
+	pt := CreatePeerTable()
+
+	measured := CreatePeer("measured", nil, true)
+	measured.SetChainStatus(10, common.Hash{1})
+	measured.SetLatency(200 * time.Millisecond)
+	unmeasured := CreatePeer("unmeasured", nil, true)
+	unmeasured.SetChainStatus(10, common.Hash{1})
+
+	pt.AddPeer(measured)
+	pt.AddPeer(unmeasured)
+
+	best := pt.BestPeer()
+	if best == nil || best.ID() != "measured" {
+		t.Fatalf("BestPeer() = %v, want measured (unmeasured latency must not win ties)", best)
+	}
+}
+
+// TestBestPeerReturnsNilWhenEmpty verifies that an empty table has no best
+// peer.
+func TestBestPeerReturnsNilWhenEmpty(t *testing.T) {
+	pt := CreatePeerTable()
+
+	if best := pt.BestPeer(); best != nil {
+		t.Fatalf("BestPeer() = %v, want nil for an empty table", best)
+	}
+}
+
+// TestPeersAboveHeightFiltersByAdvertisedHeight verifies that only peers at
+// or above the given height are returned.
+func TestPeersAboveHeightFiltersByAdvertisedHeight(t *testing.T) {
+	pt := CreatePeerTable()
+
+	low := CreatePeer("low", nil, true)
+	low.SetChainStatus(5, common.Hash{1})
+	atThreshold := CreatePeer("at-threshold", nil, true)
+	atThreshold.SetChainStatus(10, common.Hash{2})
+	high := CreatePeer("high", nil, true)
+	high.SetChainStatus(15, common.Hash{3})
+
+	pt.AddPeer(low)
+	pt.AddPeer(atThreshold)
+	pt.AddPeer(high)
+
+	peers := pt.PeersAboveHeight(10)
+	if len(peers) != 2 {
+		t.Fatalf("len(peers) = %d, want 2", len(peers))
+	}
+	for _, p := range peers {
+		if p.ID() == "low" {
+			t.Fatalf("PeersAboveHeight(10) included a peer below the threshold")
+		}
+	}
+}
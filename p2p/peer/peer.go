@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/thetatoken/ukulele/common"
+	"github.com/thetatoken/theta/common/util"
+	nu "github.com/thetatoken/theta/p2p/netutil"
+)
+
+var logger = util.GetLoggerForModule("peer")
+
+// defaultSendQueueCap is the max number of outstanding outbound messages
+// queued for a single channel before the channel is considered saturated.
+const defaultSendQueueCap = 256
+
+// Peer models a remote node this node is (or was) connected to.
+type Peer struct {
+	mutex *sync.Mutex
+
+	id         string
+	netAddress *nu.NetAddress
+
+	isSeed      bool
+	isOutbound  bool
+	stopped     bool
+	connectedAt time.Time
+
+	channels     map[common.ChannelIDEnum]bool
+	sendQueueLen map[common.ChannelIDEnum]int
+	sendQueueCap int
+
+	chainStatus chainStatus
+}
+
+// CreatePeer creates an instance of Peer
+func CreatePeer(id string, netAddress *nu.NetAddress, isOutbound bool) *Peer {
+	return &Peer{
+		mutex:       &sync.Mutex{},
+		id:          id,
+		netAddress:  netAddress,
+		isOutbound:  isOutbound,
+		connectedAt: time.Now(),
+
+		channels:     make(map[common.ChannelIDEnum]bool),
+		sendQueueLen: make(map[common.ChannelIDEnum]int),
+		sendQueueCap: defaultSendQueueCap,
+	}
+}
+
+// ID returns the unique identifier of the peer
+func (p *Peer) ID() string {
+	return p.id
+}
+
+// NetAddress returns the peer's network address
+func (p *Peer) NetAddress() *nu.NetAddress {
+	return p.netAddress
+}
+
+// IsOutbound indicates whether this is an outbound peer, i.e. a peer this
+// node dialed, as opposed to one that dialed in
+func (p *Peer) IsOutbound() bool {
+	return p.isOutbound
+}
+
+// IsSeed indicates whether the peer is a seed peer
+func (p *Peer) IsSeed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.isSeed
+}
+
+// SetSeed marks/unmarks the peer as a seed peer
+func (p *Peer) SetSeed(isSeed bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.isSeed = isSeed
+}
+
+// Stop marks the peer as stopped. Peer holds no connection of its own, so
+// nothing else is torn down here.
+func (p *Peer) Stop() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+}
+
+// IsStopped indicates whether the peer has already been stopped
+func (p *Peer) IsStopped() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.stopped
+}
+
+// IdleSince returns the time this peer was last known to be active, i.e.
+// the time of its last chain status update, or the time it connected if it
+// has never sent one. Used to pick a purge/eviction candidate.
+func (p *Peer) IdleSince() time.Time {
+	p.mutex.Lock()
+	lastStatusAt := p.chainStatus.lastStatusAt
+	p.mutex.Unlock()
+
+	if lastStatusAt.IsZero() {
+		return p.connectedAt
+	}
+	return lastStatusAt
+}
+
+// SetChannels records the set of channels this peer subscribes to, as
+// advertised during the handshake.
+func (p *Peer) SetChannels(channelIDs []common.ChannelIDEnum) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.channels = make(map[common.ChannelIDEnum]bool, len(channelIDs))
+	for _, channelID := range channelIDs {
+		p.channels[channelID] = true
+	}
+}
+
+// HasChannel indicates whether the peer subscribes to the given channel
+func (p *Peer) HasChannel(channelID common.ChannelIDEnum) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.channels[channelID]
+}
+
+// SetSendQueueLen records the current number of messages queued to be sent
+// to this peer on the given channel
+func (p *Peer) SetSendQueueLen(channelID common.ChannelIDEnum, length int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.sendQueueLen[channelID] = length
+}
+
+// IsSendQueueFull indicates whether the peer's send queue for the given
+// channel is currently saturated, i.e. further messages on that channel
+// should be skipped rather than queued
+func (p *Peer) IsSendQueueFull(channelID common.ChannelIDEnum) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.sendQueueLen[channelID] >= p.sendQueueCap
+}
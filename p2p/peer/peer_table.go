@@ -1,8 +1,8 @@
 package peer
 
 import (
-	"math/rand"
 	"sync"
+	"time"
 
 	mm "github.com/thetatoken/theta/common/math"
 	nu "github.com/thetatoken/theta/p2p/netutil"
@@ -28,6 +28,16 @@ type PeerTable struct {
 	peerMap map[string]*Peer // map: peerID |-> *Peer
 	peers   []*Peer          // For iteration with deterministic order
 	addrMap map[*nu.NetAddress]*Peer
+
+	misbehaviorScores map[string]int      // map: peerID |-> accumulated misbehavior score
+	banList           map[string]banEntry // map: addr.String() |-> banEntry
+	banThreshold      int
+	persistenceDir    string
+
+	minFanout       int
+	broadcastFactor float64
+
+	addrBook *AddressBook
 }
 
 type PeerIDAddress struct {
@@ -41,11 +51,23 @@ func CreatePeerTable() PeerTable {
 		mutex:   &sync.Mutex{},
 		peerMap: make(map[string]*Peer),
 		addrMap: make(map[*nu.NetAddress]*Peer),
+
+		misbehaviorScores: make(map[string]int),
+		banList:           make(map[string]banEntry),
+		banThreshold:      defaultBanThreshold,
+
+		addrBook: NewAddressBook(""),
 	}
 }
 
-// AddPeer adds the given peer to the PeerTable
+// AddPeer adds the given peer to the PeerTable. It rejects peers whose
+// address is currently banned.
 func (pt *PeerTable) AddPeer(peer *Peer) bool {
+	if pt.IsBanned(peer.NetAddress()) {
+		logger.Warnf("Rejecting banned peer: %v", peer.ID())
+		return false
+	}
+
 	pt.mutex.Lock()
 	defer pt.mutex.Unlock()
 
@@ -73,6 +95,14 @@ func (pt *PeerTable) AddPeer(peer *Peer) bool {
 	pt.peerMap[peer.ID()] = peer
 	pt.addrMap[peer.NetAddress()] = peer
 
+	// A successful connection is itself evidence the address is reachable,
+	// so transparently promote it into the address book's tried bucket.
+	pt.addrBook.AddAddress(peer.NetAddress(), peer.NetAddress())
+	pt.addrBook.MarkGood(peer.NetAddress())
+	if peer.IsSeed() {
+		pt.addrBook.MarkSeed(peer.NetAddress())
+	}
+
 	return true
 }
 
@@ -96,22 +126,33 @@ func (pt *PeerTable) DeletePeer(peerID string) {
 	}
 }
 
-// PurgeOldestPeer purges the oldest peer from the PeerTable
+// PurgeOldestPeer purges the peer that has been idle/unreachable the
+// longest from the PeerTable. Seed peers are never purged.
 func (pt *PeerTable) PurgeOldestPeer() *Peer {
 	pt.mutex.Lock()
 	defer pt.mutex.Unlock()
 
-	var peer *Peer
+	oldestIdx := -1
+	var oldestIdleSince time.Time
 	for idx, pr := range pt.peers {
-		if !pr.IsSeed() {
-			peer = pt.peers[idx]
+		if pr.IsSeed() {
+			continue
+		}
+		idleSince := pr.IdleSince()
+		if oldestIdx == -1 || idleSince.Before(oldestIdleSince) {
+			oldestIdx = idx
+			oldestIdleSince = idleSince
 		}
 	}
-	if peer != nil {
-		delete(pt.peerMap, peer.ID())
-		pt.peers = pt.peers[1:]
+	if oldestIdx == -1 {
+		return nil
 	}
-	
+
+	peer := pt.peers[oldestIdx]
+	delete(pt.peerMap, peer.ID())
+	delete(pt.addrMap, peer.NetAddress())
+	pt.peers = append(pt.peers[:oldestIdx], pt.peers[oldestIdx+1:]...)
+
 	return peer
 }
 
@@ -169,43 +210,48 @@ func (pt *PeerTable) GetAllPeers() *([]*Peer) {
 	return &ret
 }
 
-// GetSelection randomly selects some peers. Suitable for peer-exchange protocols.
+// GetSelection randomly selects some known addresses, drawn proportionally
+// from the address book's tried and new buckets. Suitable for peer-exchange
+// protocols.
 func (pt *PeerTable) GetSelection() (peerIDAddrs []PeerIDAddress) {
-	pt.mutex.Lock()
-	defer pt.mutex.Unlock()
-
-	if len(pt.peers) == 0 {
+	total := pt.addrBook.Size()
+	if total == 0 {
 		return nil
 	}
 
-	peers := make([]*Peer, len(pt.peers))
-	copy(peers, pt.peers)
+	numAddrs := mm.MaxInt(
+		mm.MinInt(minGetSelection, total),
+		total*getSelectionPercent/100)
+	numAddrs = mm.MinInt(maxGetSelection, numAddrs)
 
-	numPeers := mm.MaxInt(
-		mm.MinInt(minGetSelection, len(peers)),
-		len(peers)*getSelectionPercent/100)
-	numPeers = mm.MinInt(maxGetSelection, numPeers)
+	addrs := pt.addrBook.GetSelection(numAddrs)
 
-	// Fisher-Yates shuffle the array. We only need to do the first
-	// `numPeers' since we are throwing the rest.
-	for i := 0; i < numPeers; i++ {
-		// pick a number between current index and the end
-		j := rand.Intn(len(peers)-i) + i
-		peers[i], peers[j] = peers[j], peers[i]
-	}
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
 
-	// slice off the limit we are willing to share.
-	peers = peers[:numPeers]
-	for _, peer := range peers {
-		peerIDAddr := PeerIDAddress{
-			ID:   peer.ID(),
-			Addr: peer.netAddress,
+	for _, addr := range addrs {
+		id := ""
+		if peer, exists := pt.addrMap[addr]; exists {
+			id = peer.ID()
 		}
-		peerIDAddrs = append(peerIDAddrs, peerIDAddr)
+		peerIDAddrs = append(peerIDAddrs, PeerIDAddress{ID: id, Addr: addr})
 	}
 	return
 }
 
+// MarkAttempt records a dial attempt against addr (its time and attempt
+// count) in the address book. Neither SelectAddress nor bucket eviction
+// looks at the recorded attempts.
+func (pt *PeerTable) MarkAttempt(addr *nu.NetAddress) {
+	pt.addrBook.MarkAttempt(addr)
+}
+
+// SelectAddress draws a single dial target from the address book, biased
+// toward the `tried` bucket by biasTowardTried.
+func (pt *PeerTable) SelectAddress(biasTowardTried float64) *nu.NetAddress {
+	return pt.addrBook.SelectAddress(biasTowardTried)
+}
+
 // GetTotalNumPeers returns the total number of peers in the PeerTable
 func (pt *PeerTable) GetTotalNumPeers() uint {
 	pt.mutex.Lock()